@@ -0,0 +1,612 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import (
+	"bytes"
+
+	"github.com/google/trillian/storage/storagepb"
+)
+
+// SuffixIndex is an Adaptive Radix Tree (ART) mapping NodeID suffixes to
+// their populated hash values. It is intended as a drop-in replacement for
+// the map[string][]byte structures that PopulateSubtreeFunc/
+// PrepareSubtreeWriteFunc implementations build up while walking a
+// SubtreeProto: for sparse subtrees the overwhelming majority of possible
+// suffixes are absent, so a radix tree with path compression uses far less
+// memory than a Go map keyed by every populated suffix string, and it
+// supports ordered iteration and prefix scans that a map cannot.
+//
+// Nodes come in four flavours - artNode4, artNode16, artNode48 and
+// artNode256 - and a node grows to the next flavour only when it runs out of
+// child slots, so it only grows as large as it needs to be. Nodes are not
+// shrunk back to a smaller flavour when children are deleted; only a Node4
+// left with a single child is collapsed into it. A SuffixIndex is not safe for
+// concurrent use without external synchronization.
+type SuffixIndex struct {
+	root artNode
+	size int
+}
+
+// NewSuffixIndex returns an empty SuffixIndex.
+func NewSuffixIndex() *SuffixIndex {
+	return &SuffixIndex{}
+}
+
+// Len returns the number of suffixes currently stored in the index.
+func (t *SuffixIndex) Len() int {
+	return t.size
+}
+
+// Insert associates hash with suffix, overwriting any previous value.
+func (t *SuffixIndex) Insert(suffix, hash []byte) {
+	value := append([]byte(nil), hash...)
+	newRoot, replaced := insertART(t.root, suffix, value, 0)
+	t.root = newRoot
+	if !replaced {
+		t.size++
+	}
+}
+
+// Delete removes suffix from the index, returning true if it was present.
+func (t *SuffixIndex) Delete(suffix []byte) bool {
+	newRoot, deleted := deleteART(t.root, suffix, 0)
+	if deleted {
+		t.root = newRoot
+		t.size--
+	}
+	return deleted
+}
+
+// Find returns the hash stored for suffix, and whether it was found.
+func (t *SuffixIndex) Find(suffix []byte) ([]byte, bool) {
+	n := t.root
+	depth := 0
+	for n != nil {
+		if leaf, ok := n.(*artLeaf); ok {
+			if bytes.Equal(leaf.key, suffix) {
+				return leaf.value, true
+			}
+			return nil, false
+		}
+		h := n.header()
+		if p := checkPrefix(h, suffix, depth); p < len(h.partial) {
+			return nil, false
+		}
+		depth += len(h.partial)
+		if depth > len(suffix) {
+			return nil, false
+		}
+		var b byte
+		next := depth < len(suffix)
+		if next {
+			b = suffix[depth]
+		}
+		if !next {
+			// Exact match on an internal node's own path: the value, if any,
+			// lives in its selfLeaf rather than in one of its children.
+			if h.selfLeaf != nil {
+				return h.selfLeaf.value, true
+			}
+			return nil, false
+		}
+		n = findChild(n, b)
+		depth++
+	}
+	return nil, false
+}
+
+// Iterate walks every suffix/hash pair in ascending key order, calling fn
+// for each. Iteration stops early if fn returns false.
+func (t *SuffixIndex) Iterate(fn func(suffix, hash []byte) bool) {
+	iterateART(t.root, fn)
+}
+
+// IteratePrefix walks every suffix/hash pair whose suffix starts with
+// prefix - i.e. every populated descendant of the NodeID whose Suffix()
+// equals prefix - in ascending key order. Iteration stops early if fn
+// returns false.
+func (t *SuffixIndex) IteratePrefix(prefix []byte, fn func(suffix, hash []byte) bool) {
+	n, depth := seekPrefix(t.root, prefix, 0)
+	if n == nil {
+		return
+	}
+	iterateART(n, fn)
+	_ = depth
+}
+
+// SubtreeIndexes holds the ART-backed indexes built from a SubtreeProto's
+// Leaves and InternalNodes maps.
+type SubtreeIndexes struct {
+	Leaves        *SuffixIndex
+	InternalNodes *SuffixIndex
+}
+
+// IndexSubtree builds SuffixIndex values over the Leaves and InternalNodes
+// maps of s, so that PopulateSubtreeFunc/PrepareSubtreeWriteFunc
+// implementations can walk populated descendants of an internal node
+// (e.g. during sparse Merkle proof construction) without materializing or
+// re-scanning the full maps.
+func IndexSubtree(s *storagepb.SubtreeProto) *SubtreeIndexes {
+	idx := &SubtreeIndexes{Leaves: NewSuffixIndex(), InternalNodes: NewSuffixIndex()}
+	for suffix, hash := range s.Leaves {
+		idx.Leaves.Insert([]byte(suffix), hash)
+	}
+	for suffix, hash := range s.InternalNodes {
+		idx.InternalNodes.Insert([]byte(suffix), hash)
+	}
+	return idx
+}
+
+// WrapPopulateSubtreeFunc adapts fn so that, after it repopulates a subtree's
+// tree-type-specific state, the subtree's Leaves and InternalNodes maps are
+// also indexed via IndexSubtree and handed to record for callers that need
+// to walk populated descendants without a full map scan.
+func WrapPopulateSubtreeFunc(fn PopulateSubtreeFunc, record func(*SubtreeIndexes)) PopulateSubtreeFunc {
+	return func(s *storagepb.SubtreeProto) error {
+		if err := fn(s); err != nil {
+			return err
+		}
+		record(IndexSubtree(s))
+		return nil
+	}
+}
+
+// artNode is implemented by artLeaf and the four inner node variants.
+type artNode interface {
+	header() *artHeader
+}
+
+// artHeader is embedded in every inner node type. partial holds the
+// compressed path segment shared by all of this node's descendants that is
+// not otherwise implied by the trie structure above it (path compression),
+// so long chains of single-child nodes collapse into one edge. selfLeaf
+// holds the value for a key that ends exactly at this node's path - i.e.
+// a key that is itself a prefix of other, longer keys stored further down
+// the same subtree.
+type artHeader struct {
+	partial  []byte
+	numChild int
+	selfLeaf *artLeaf
+}
+
+func (h *artHeader) header() *artHeader { return h }
+
+// artLeaf stores the full suffix key and its associated hash. Leaves are
+// always found at the end of a lookup path, never in the interior.
+type artLeaf struct {
+	key   []byte
+	value []byte
+}
+
+func (l *artLeaf) header() *artHeader { return nil }
+
+type artChild struct {
+	b byte
+	n artNode
+}
+
+type artNode4 struct {
+	artHeader
+	keys     [4]byte
+	children [4]artNode
+}
+
+type artNode16 struct {
+	artHeader
+	keys     [16]byte
+	children [16]artNode
+}
+
+type artNode48 struct {
+	artHeader
+	index    [256]uint8 // 0 means "absent", 1-48 is children[index-1].
+	children [48]artNode
+}
+
+type artNode256 struct {
+	artHeader
+	children [256]artNode
+}
+
+func checkPrefix(h *artHeader, key []byte, depth int) int {
+	i := 0
+	for ; i < len(h.partial); i++ {
+		if depth+i >= len(key) || key[depth+i] != h.partial[i] {
+			break
+		}
+	}
+	return i
+}
+
+func findChild(n artNode, b byte) artNode {
+	switch tn := n.(type) {
+	case *artNode4:
+		for i := 0; i < tn.numChild; i++ {
+			if tn.keys[i] == b {
+				return tn.children[i]
+			}
+		}
+	case *artNode16:
+		for i := 0; i < tn.numChild; i++ {
+			if tn.keys[i] == b {
+				return tn.children[i]
+			}
+		}
+	case *artNode48:
+		if idx := tn.index[b]; idx != 0 {
+			return tn.children[idx-1]
+		}
+	case *artNode256:
+		return tn.children[b]
+	}
+	return nil
+}
+
+func addChild(n artNode, b byte, child artNode) artNode {
+	switch tn := n.(type) {
+	case *artNode4:
+		for i := 0; i < tn.numChild; i++ {
+			if tn.keys[i] == b {
+				tn.children[i] = child
+				return tn
+			}
+		}
+		if tn.numChild < 4 {
+			tn.keys[tn.numChild] = b
+			tn.children[tn.numChild] = child
+			tn.numChild++
+			return tn
+		}
+		grown := &artNode16{artHeader: tn.artHeader}
+		copy(grown.keys[:], tn.keys[:])
+		copy(grown.children[:], tn.children[:])
+		grown.numChild = tn.numChild
+		return addChild(grown, b, child)
+	case *artNode16:
+		for i := 0; i < tn.numChild; i++ {
+			if tn.keys[i] == b {
+				tn.children[i] = child
+				return tn
+			}
+		}
+		if tn.numChild < 16 {
+			tn.keys[tn.numChild] = b
+			tn.children[tn.numChild] = child
+			tn.numChild++
+			return tn
+		}
+		grown := &artNode48{artHeader: tn.artHeader}
+		for i := 0; i < tn.numChild; i++ {
+			grown.children[i] = tn.children[i]
+			grown.index[tn.keys[i]] = uint8(i + 1)
+		}
+		grown.numChild = tn.numChild
+		return addChild(grown, b, child)
+	case *artNode48:
+		if idx := tn.index[b]; idx != 0 {
+			tn.children[idx-1] = child
+			return tn
+		}
+		if tn.numChild < 48 {
+			// removeChild leaves holes in children, so take the first free
+			// slot rather than assuming slots 0..numChild-1 are the used ones.
+			slot := 0
+			for tn.children[slot] != nil {
+				slot++
+			}
+			tn.children[slot] = child
+			tn.index[b] = uint8(slot + 1)
+			tn.numChild++
+			return tn
+		}
+		grown := &artNode256{artHeader: tn.artHeader}
+		for i, idx := range tn.index {
+			if idx != 0 {
+				grown.children[i] = tn.children[idx-1]
+			}
+		}
+		return addChild(grown, b, child)
+	case *artNode256:
+		if tn.children[b] == nil {
+			tn.numChild++
+		}
+		tn.children[b] = child
+		return tn
+	}
+	return n
+}
+
+func removeChild(n artNode, b byte) artNode {
+	switch tn := n.(type) {
+	case *artNode4:
+		for i := 0; i < tn.numChild; i++ {
+			if tn.keys[i] == b {
+				tn.numChild--
+				tn.keys[i] = tn.keys[tn.numChild]
+				tn.children[i] = tn.children[tn.numChild]
+				tn.children[tn.numChild] = nil
+				break
+			}
+		}
+	case *artNode16:
+		for i := 0; i < tn.numChild; i++ {
+			if tn.keys[i] == b {
+				tn.numChild--
+				tn.keys[i] = tn.keys[tn.numChild]
+				tn.children[i] = tn.children[tn.numChild]
+				tn.children[tn.numChild] = nil
+				break
+			}
+		}
+	case *artNode48:
+		if idx := tn.index[b]; idx != 0 {
+			tn.children[idx-1] = nil
+			tn.index[b] = 0
+			tn.numChild--
+		}
+	case *artNode256:
+		if tn.children[b] != nil {
+			tn.children[b] = nil
+			tn.numChild--
+		}
+	}
+	return n
+}
+
+// insertART inserts key/value under n at the given depth, returning the
+// (possibly new) subtree root and whether an existing leaf was replaced.
+func insertART(n artNode, key, value []byte, depth int) (artNode, bool) {
+	if n == nil {
+		return &artLeaf{key: append([]byte(nil), key...), value: value}, false
+	}
+	if leaf, ok := n.(*artLeaf); ok {
+		if bytes.Equal(leaf.key, key) {
+			leaf.value = value
+			return leaf, true
+		}
+		// Split: create a Node4 holding both the existing leaf and the new
+		// one, with a partial prefix of whatever bytes they share beyond
+		// depth. If one key is a strict prefix of the other, the shorter
+		// one's value has no byte left to branch on and becomes the new
+		// node's selfLeaf instead of a child.
+		i := depth
+		for i < len(leaf.key) && i < len(key) && leaf.key[i] == key[i] {
+			i++
+		}
+		branch := &artNode4{artHeader: artHeader{partial: append([]byte(nil), key[depth:i]...)}}
+		newLeaf := &artLeaf{key: append([]byte(nil), key...), value: value}
+		switch {
+		case i == len(leaf.key) && i == len(key):
+			// Unreachable: equal-length equal keys are handled above.
+		case i == len(leaf.key):
+			branch.selfLeaf = leaf
+			branch = addChild(branch, key[i], newLeaf).(*artNode4)
+		case i == len(key):
+			branch.selfLeaf = newLeaf
+			branch = addChild(branch, leaf.key[i], leaf).(*artNode4)
+		default:
+			branch = addChild(branch, leaf.key[i], leaf).(*artNode4)
+			branch = addChild(branch, key[i], newLeaf).(*artNode4)
+		}
+		return branch, false
+	}
+
+	h := n.header()
+	p := checkPrefix(h, key, depth)
+	if p != len(h.partial) {
+		// The new key diverges partway through this node's compressed
+		// prefix: split the prefix and insert a new branch above n.
+		branch := &artNode4{artHeader: artHeader{partial: append([]byte(nil), h.partial[:p]...)}}
+		oldByte := h.partial[p]
+		h.partial = h.partial[p+1:]
+		branch = addChild(branch, oldByte, n).(*artNode4)
+
+		leaf := &artLeaf{key: append([]byte(nil), key...), value: value}
+		if depth+p < len(key) {
+			branch = addChild(branch, key[depth+p], leaf).(*artNode4)
+		} else {
+			// key ends exactly where the old node's prefix diverges: it has
+			// no byte left to branch on, so it becomes the new branch's own
+			// value rather than a child keyed by a sentinel byte.
+			branch.selfLeaf = leaf
+		}
+		return branch, false
+	}
+	depth += len(h.partial)
+
+	if depth == len(key) {
+		// key ends exactly at this internal node's path: it is a prefix of
+		// whatever longer keys are stored in n's children, so its value
+		// lives in selfLeaf rather than displacing any of them.
+		if h.selfLeaf != nil {
+			h.selfLeaf.value = value
+			return n, true
+		}
+		h.selfLeaf = &artLeaf{key: append([]byte(nil), key...), value: value}
+		return n, false
+	}
+
+	b := key[depth]
+	child := findChild(n, b)
+	newChild, replaced := insertART(child, key, value, depth+1)
+	return addChild(n, b, newChild), replaced
+}
+
+// deleteART removes key from the subtree rooted at n, returning the
+// (possibly new, possibly nil) subtree root and whether a leaf was removed.
+func deleteART(n artNode, key []byte, depth int) (artNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+	if leaf, ok := n.(*artLeaf); ok {
+		if bytes.Equal(leaf.key, key) {
+			return nil, true
+		}
+		return n, false
+	}
+	h := n.header()
+	p := checkPrefix(h, key, depth)
+	if p != len(h.partial) {
+		return n, false
+	}
+	depth += len(h.partial)
+	if depth == len(key) {
+		if h.selfLeaf == nil {
+			return n, false
+		}
+		h.selfLeaf = nil
+		return collapse(n), true
+	}
+	if depth > len(key) {
+		return n, false
+	}
+	b := key[depth]
+	child := findChild(n, b)
+	newChild, deleted := deleteART(child, key, depth+1)
+	if !deleted {
+		return n, false
+	}
+	if newChild == nil {
+		n = removeChild(n, b)
+	} else {
+		n = addChild(n, b, newChild)
+	}
+	return collapse(n), true
+}
+
+// collapse shrinks a node left with no children into its selfLeaf (or nil,
+// if it has none), and a Node4 left with exactly one child and no selfLeaf
+// into that child, re-attaching the parent's partial prefix so path
+// compression is maintained after deletions.
+func collapse(n artNode) artNode {
+	h := n.header()
+	if h == nil {
+		return n
+	}
+	if h.numChild == 0 {
+		if h.selfLeaf != nil {
+			return h.selfLeaf
+		}
+		return nil
+	}
+	tn, ok := n.(*artNode4)
+	if !ok || tn.numChild != 1 || tn.selfLeaf != nil {
+		return n
+	}
+	child := tn.children[0]
+	b := tn.keys[0]
+	ch := child.header()
+	if ch == nil {
+		// Child is a leaf: nothing to merge prefixes with, just replace.
+		return child
+	}
+	merged := append(append(append([]byte(nil), tn.partial...), b), ch.partial...)
+	ch.partial = merged
+	return child
+}
+
+// seekPrefix walks n looking for the subtree whose full key path starts
+// with prefix, returning that subtree's root (which may be n itself).
+func seekPrefix(n artNode, prefix []byte, depth int) (artNode, int) {
+	for n != nil {
+		if leaf, ok := n.(*artLeaf); ok {
+			if len(leaf.key) >= len(prefix) && bytes.Equal(leaf.key[:len(prefix)], prefix) {
+				return n, depth
+			}
+			return nil, depth
+		}
+		h := n.header()
+		remaining := len(prefix) - depth
+		if remaining <= 0 {
+			return n, depth
+		}
+		cmpLen := len(h.partial)
+		if cmpLen > remaining {
+			cmpLen = remaining
+		}
+		for i := 0; i < cmpLen; i++ {
+			if h.partial[i] != prefix[depth+i] {
+				return nil, depth
+			}
+		}
+		depth += len(h.partial)
+		if depth >= len(prefix) {
+			return n, depth
+		}
+		n = findChild(n, prefix[depth])
+		depth++
+	}
+	return nil, depth
+}
+
+// iterateART performs an in-order (ascending key byte) walk of the subtree
+// rooted at n.
+func iterateART(n artNode, fn func(suffix, hash []byte) bool) bool {
+	if n == nil {
+		return true
+	}
+	if leaf, ok := n.(*artLeaf); ok {
+		return fn(leaf.key, leaf.value)
+	}
+	if h := n.header(); h.selfLeaf != nil {
+		// selfLeaf's key is a strict prefix of every key reachable through
+		// this node's children, so it sorts before all of them.
+		if !fn(h.selfLeaf.key, h.selfLeaf.value) {
+			return false
+		}
+	}
+	for _, c := range sortedChildren(n) {
+		if !iterateART(c.n, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedChildren(n artNode) []artChild {
+	var out []artChild
+	switch tn := n.(type) {
+	case *artNode4:
+		for i := 0; i < tn.numChild; i++ {
+			out = append(out, artChild{tn.keys[i], tn.children[i]})
+		}
+	case *artNode16:
+		for i := 0; i < tn.numChild; i++ {
+			out = append(out, artChild{tn.keys[i], tn.children[i]})
+		}
+	case *artNode48:
+		for b, idx := range tn.index {
+			if idx != 0 {
+				out = append(out, artChild{byte(b), tn.children[idx-1]})
+			}
+		}
+		return out // already ascending by construction of the range over index.
+	case *artNode256:
+		for b, c := range tn.children {
+			if c != nil {
+				out = append(out, artChild{byte(b), c})
+			}
+		}
+		return out // already ascending.
+	}
+	// Node4/Node16 children are unordered as inserted; sort by key byte.
+	for i := 1; i < len(out); i++ {
+		for j := i; j > 0 && out[j-1].b > out[j].b; j-- {
+			out[j-1], out[j] = out[j], out[j-1]
+		}
+	}
+	return out
+}
@@ -0,0 +1,222 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestSuffixIndexInsertKeepsKeysSharingByte(t *testing.T) {
+	idx := NewSuffixIndex()
+	idx.Insert([]byte{0xAA, 0x01, 0x01}, []byte("a"))
+	idx.Insert([]byte{0xAA, 0x02}, []byte("b"))
+	idx.Insert([]byte{0xAA, 0x01, 0x02}, []byte("c"))
+
+	for _, want := range []struct {
+		key   []byte
+		value string
+	}{
+		{[]byte{0xAA, 0x01, 0x01}, "a"},
+		{[]byte{0xAA, 0x02}, "b"},
+		{[]byte{0xAA, 0x01, 0x02}, "c"},
+	} {
+		got, ok := idx.Find(want.key)
+		if !ok {
+			t.Fatalf("Find(%v) = not found, want %q", want.key, want.value)
+		}
+		if !bytes.Equal(got, []byte(want.value)) {
+			t.Fatalf("Find(%v) = %q, want %q", want.key, got, want.value)
+		}
+	}
+	if got, want := idx.Len(), 3; got != want {
+		t.Errorf("Len() = %d, want %d", got, want)
+	}
+}
+
+func TestSuffixIndexInsertReplacesExistingValue(t *testing.T) {
+	idx := NewSuffixIndex()
+	idx.Insert([]byte{0x01, 0x02}, []byte("first"))
+	idx.Insert([]byte{0x01, 0x03}, []byte("sibling"))
+	idx.Insert([]byte{0x01, 0x02}, []byte("second"))
+
+	got, ok := idx.Find([]byte{0x01, 0x02})
+	if !ok || !bytes.Equal(got, []byte("second")) {
+		t.Fatalf("Find = %q, %v, want %q, true", got, ok, "second")
+	}
+	if got, want := idx.Len(), 2; got != want {
+		t.Errorf("Len() = %d, want %d", got, want)
+	}
+}
+
+func TestSuffixIndexInsertFindDeleteRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	model := map[string][]byte{}
+	idx := NewSuffixIndex()
+
+	for i := 0; i < 2000; i++ {
+		key := make([]byte, 1+rnd.Intn(4))
+		for j := range key {
+			key[j] = byte(rnd.Intn(8)) // small alphabet to force key collisions/shared prefixes.
+		}
+		value := []byte(fmt.Sprintf("v%d", i))
+		model[string(key)] = value
+		idx.Insert(key, value)
+	}
+
+	if got, want := idx.Len(), len(model); got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+	for key, want := range model {
+		got, ok := idx.Find([]byte(key))
+		if !ok {
+			t.Fatalf("Find(%q) = not found, want %q", key, want)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Find(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	seen := map[string][]byte{}
+	idx.Iterate(func(suffix, hash []byte) bool {
+		seen[string(suffix)] = hash
+		return true
+	})
+	if len(seen) != len(model) {
+		t.Fatalf("Iterate visited %d keys, want %d", len(seen), len(model))
+	}
+
+	i := 0
+	for key := range model {
+		if i%2 == 0 {
+			if !idx.Delete([]byte(key)) {
+				t.Fatalf("Delete(%q) = false, want true", key)
+			}
+			delete(model, key)
+		}
+		i++
+	}
+	if got, want := idx.Len(), len(model); got != want {
+		t.Fatalf("Len() after deletes = %d, want %d", got, want)
+	}
+	for key, want := range model {
+		got, ok := idx.Find([]byte(key))
+		if !ok || !bytes.Equal(got, want) {
+			t.Fatalf("Find(%q) after deletes = %q, %v, want %q, true", key, got, ok, want)
+		}
+	}
+}
+
+func TestSuffixIndexIteratePrefix(t *testing.T) {
+	idx := NewSuffixIndex()
+	entries := map[string]string{
+		"\xaa\x01\x01": "a",
+		"\xaa\x01\x02": "b",
+		"\xaa\x02":     "c",
+		"\xbb":         "d",
+	}
+	for k, v := range entries {
+		idx.Insert([]byte(k), []byte(v))
+	}
+
+	var got []string
+	idx.IteratePrefix([]byte{0xAA}, func(suffix, hash []byte) bool {
+		got = append(got, string(suffix))
+		return true
+	})
+	want := []string{"\xaa\x01\x01", "\xaa\x01\x02", "\xaa\x02"}
+	if len(got) != len(want) {
+		t.Fatalf("IteratePrefix visited %d suffixes, want %d: got=%q want=%q", len(got), len(want), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("IteratePrefix()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func buildSuffixIndex(n int) (*SuffixIndex, [][]byte) {
+	idx := NewSuffixIndex()
+	keys := make([][]byte, n)
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < n; i++ {
+		key := make([]byte, 4)
+		rnd.Read(key)
+		keys[i] = key
+		idx.Insert(key, []byte{byte(i)})
+	}
+	return idx, keys
+}
+
+func buildSuffixMap(n int) (map[string][]byte, [][]byte) {
+	m := make(map[string][]byte, n)
+	keys := make([][]byte, n)
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < n; i++ {
+		key := make([]byte, 4)
+		rnd.Read(key)
+		keys[i] = key
+		m[string(key)] = []byte{byte(i)}
+	}
+	return m, keys
+}
+
+func BenchmarkSuffixIndexFind(b *testing.B) {
+	idx, keys := buildSuffixIndex(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Find(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkSuffixMapFind(b *testing.B) {
+	m, keys := buildSuffixMap(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = m[string(keys[i%len(keys)])]
+	}
+}
+
+func BenchmarkSuffixIndexInsert(b *testing.B) {
+	rnd := rand.New(rand.NewSource(3))
+	idx := NewSuffixIndex()
+	keys := make([][]byte, b.N)
+	for i := range keys {
+		key := make([]byte, 4)
+		rnd.Read(key)
+		keys[i] = key
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Insert(keys[i], []byte{byte(i)})
+	}
+}
+
+func BenchmarkSuffixMapInsert(b *testing.B) {
+	rnd := rand.New(rand.NewSource(3))
+	m := make(map[string][]byte)
+	keys := make([][]byte, b.N)
+	for i := range keys {
+		key := make([]byte, 4)
+		rnd.Read(key)
+		keys[i] = key
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m[string(keys[i])] = []byte{byte(i)}
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import "bytes"
+
+// PrefixTrie is an immutable, copy-on-write radix trie keyed by NodeID
+// (Path plus PrefixLenBits), modelled on hashicorp/go-immutable-radix. Each
+// write produces a new root that shares unmodified structure with the old
+// one, so a *PrefixTrie[T] can be handed out as a point-in-time snapshot
+// and read lock-free while a writer builds the next version.
+//
+// The intended consumer is the in-process subtree cache used during
+// sequencing: today it is a sync.Map that cannot provide a single
+// consistent view across a whole SetLeaves batch. With PrefixTrie, a
+// sequencer transaction takes a Snapshot, does all its reads against that,
+// and stages writes in a Txn that only becomes the new root if the
+// surrounding storage commit succeeds.
+type PrefixTrie[T any] struct {
+	root *trieNode[T]
+	size int
+}
+
+type trieNode[T any] struct {
+	// prefix is the path-compressed key segment covered by this node, one
+	// byte per bit of NodeID.Path (see nodeIDKey) so that the generic
+	// byte-slice bytes.HasPrefix comparisons used throughout this file are
+	// exactly bit-level prefix comparisons, including for NodeIDs whose
+	// PrefixLenBits ends mid-byte.
+	prefix []byte
+	leaf   *trieLeaf[T]
+	edges  []trieEdge[T]
+}
+
+type trieEdge[T any] struct {
+	label byte
+	node  *trieNode[T]
+}
+
+type trieLeaf[T any] struct {
+	key   []byte
+	value T
+}
+
+// NewPrefixTrie returns an empty PrefixTrie.
+func NewPrefixTrie[T any]() *PrefixTrie[T] {
+	return &PrefixTrie[T]{root: &trieNode[T]{}}
+}
+
+// Len returns the number of entries in the trie.
+func (t *PrefixTrie[T]) Len() int {
+	return t.size
+}
+
+// nodeIDKey encodes a NodeID into a byte key suitable for the trie: one byte
+// per significant bit of Path, holding that bit's value (0 or 1), MSB
+// first. Packing whole masked bytes instead (as Suffix/AsKey do) would make
+// bytes.HasPrefix a whole-byte comparison, which silently misses bit-level
+// prefix relationships whenever PrefixLenBits ends mid-byte - e.g. a 4-bit
+// id and a 7-bit descendant that shares those 4 bits but has further
+// significant low bits set in the same byte. Expanding to one byte per bit
+// makes byte-slice prefix comparison exactly bit-level prefix comparison,
+// at the cost of a larger key (bounded by PrefixLenBits, not PrefixLenBits
+// rounded up to a Merkle tree's max depth).
+func nodeIDKey(id NodeID) []byte {
+	key := make([]byte, id.PrefixLenBits)
+	for i := range key {
+		byteIdx := i / 8
+		bitIdx := 7 - uint(i%8)
+		key[i] = (id.Path[byteIdx] >> bitIdx) & 1
+	}
+	return key
+}
+
+// Get returns the value stored for id, if any.
+func (t *PrefixTrie[T]) Get(id NodeID) (T, bool) {
+	return get(t.root, nodeIDKey(id))
+}
+
+func get[T any](n *trieNode[T], key []byte) (T, bool) {
+	for {
+		if len(key) == 0 && len(n.prefix) == 0 {
+			if n.leaf != nil {
+				return n.leaf.value, true
+			}
+			var zero T
+			return zero, false
+		}
+		if !bytes.HasPrefix(key, n.prefix) {
+			var zero T
+			return zero, false
+		}
+		key = key[len(n.prefix):]
+		if len(key) == 0 {
+			if n.leaf != nil {
+				return n.leaf.value, true
+			}
+			var zero T
+			return zero, false
+		}
+		child := edgeFor(n, key[0])
+		if child == nil {
+			var zero T
+			return zero, false
+		}
+		n = child
+	}
+}
+
+// LongestPrefix returns the entry whose key is the longest prefix of id's
+// key, along with the matched NodeID and true. If no stored key is a
+// prefix of id, ok is false.
+func (t *PrefixTrie[T]) LongestPrefix(id NodeID) (matched NodeID, value T, ok bool) {
+	key := nodeIDKey(id)
+	full := key // key is trimmed as the walk descends; leaf keys are full keys.
+	n := t.root
+	var lastLeaf *trieLeaf[T]
+	for {
+		if n.leaf != nil && bytes.HasPrefix(full, n.leaf.key) {
+			lastLeaf = n.leaf
+		}
+		if len(key) == 0 || !bytes.HasPrefix(key, n.prefix) {
+			break
+		}
+		key = key[len(n.prefix):]
+		if len(key) == 0 {
+			break
+		}
+		child := edgeFor(n, key[0])
+		if child == nil {
+			break
+		}
+		n = child
+	}
+	if lastLeaf == nil {
+		var zero T
+		return NodeID{}, zero, false
+	}
+	return decodeNodeIDKey(lastLeaf.key), lastLeaf.value, true
+}
+
+func decodeNodeIDKey(key []byte) NodeID {
+	path := make([]byte, (len(key)+7)/8)
+	for i, bit := range key {
+		if bit != 0 {
+			path[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return NodeID{Path: path, PrefixLenBits: len(key)}
+}
+
+// WalkPrefix calls fn for every NodeID stored in the trie whose key starts
+// with id's key (i.e. every descendant of id, including id itself),
+// stopping early if fn returns false.
+func (t *PrefixTrie[T]) WalkPrefix(id NodeID, fn func(NodeID, T) bool) {
+	prefix := nodeIDKey(id)
+	n, remaining, ok := seekTrie(t.root, prefix)
+	if !ok {
+		return
+	}
+	_ = remaining
+	walkTrie(n, fn)
+}
+
+func seekTrie[T any](n *trieNode[T], prefix []byte) (*trieNode[T], []byte, bool) {
+	for {
+		if len(prefix) == 0 {
+			return n, prefix, true
+		}
+		cmp := n.prefix
+		if len(cmp) > len(prefix) {
+			cmp = cmp[:len(prefix)]
+		}
+		if !bytes.HasPrefix(prefix, cmp) && !bytes.HasPrefix(cmp, prefix) {
+			return nil, nil, false
+		}
+		if len(n.prefix) >= len(prefix) {
+			return n, nil, true
+		}
+		prefix = prefix[len(n.prefix):]
+		child := edgeFor(n, prefix[0])
+		if child == nil {
+			return nil, nil, false
+		}
+		n = child
+	}
+}
+
+func walkTrie[T any](n *trieNode[T], fn func(NodeID, T) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.leaf != nil {
+		if !fn(decodeNodeIDKey(n.leaf.key), n.leaf.value) {
+			return false
+		}
+	}
+	for _, e := range n.edges {
+		if !walkTrie(e.node, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func edgeFor[T any](n *trieNode[T], label byte) *trieNode[T] {
+	for _, e := range n.edges {
+		if e.label == label {
+			return e.node
+		}
+	}
+	return nil
+}
+
+// Txn is a mutable, structural-sharing transaction against a PrefixTrie
+// snapshot. Insert and Delete build a new tree of nodes lazily copied along
+// the write path, leaving nodes outside that path shared with the
+// originating snapshot. Commit publishes the accumulated writes as a new
+// *PrefixTrie.
+type Txn[T any] struct {
+	root *trieNode[T]
+	size int
+}
+
+// Txn begins a new transaction rooted at this snapshot. The snapshot itself
+// is never mutated.
+func (t *PrefixTrie[T]) Txn() *Txn[T] {
+	return &Txn[T]{root: t.root, size: t.size}
+}
+
+// Snapshot returns the immutable trie as it stands right now. It is safe to
+// read concurrently with further Txn writes elsewhere, because those writes
+// only ever produce new nodes, never mutate existing ones.
+func (t *PrefixTrie[T]) Snapshot() *PrefixTrie[T] {
+	return t
+}
+
+// Insert associates value with id, returning the previous value if any.
+func (x *Txn[T]) Insert(id NodeID, value T) (old T, replaced bool) {
+	key := nodeIDKey(id)
+	newRoot, old, replaced := insertTrie(x.root, key, key, value)
+	x.root = newRoot
+	if !replaced {
+		x.size++
+	}
+	return old, replaced
+}
+
+func insertTrie[T any](n *trieNode[T], fullKey, key []byte, value T) (*trieNode[T], T, bool) {
+	if n == nil {
+		n = &trieNode[T]{}
+	}
+	if !bytes.HasPrefix(key, n.prefix) {
+		// Diverges partway through n's compressed prefix: split it.
+		common := commonPrefixLen(key, n.prefix)
+		split := &trieNode[T]{prefix: append([]byte(nil), n.prefix[:common]...)}
+		oldChild := &trieNode[T]{prefix: append([]byte(nil), n.prefix[common:]...), leaf: n.leaf, edges: n.edges}
+		split.edges = []trieEdge[T]{{label: oldChild.prefix[0], node: oldChild}}
+		rest := key[common:]
+		if len(rest) == 0 {
+			split.leaf = &trieLeaf[T]{key: fullKey, value: value}
+		} else {
+			newChild := &trieNode[T]{prefix: append([]byte(nil), rest...), leaf: &trieLeaf[T]{key: fullKey, value: value}}
+			split.edges = append(split.edges, trieEdge[T]{label: rest[0], node: newChild})
+			if rest[0] < oldChild.prefix[0] {
+				split.edges[0], split.edges[1] = split.edges[1], split.edges[0]
+			}
+		}
+		var zero T
+		return split, zero, false
+	}
+	key = key[len(n.prefix):]
+	clone := &trieNode[T]{prefix: n.prefix, leaf: n.leaf, edges: append([]trieEdge[T](nil), n.edges...)}
+	if len(key) == 0 {
+		var old T
+		replaced := clone.leaf != nil
+		if replaced {
+			old = clone.leaf.value
+		}
+		clone.leaf = &trieLeaf[T]{key: fullKey, value: value}
+		return clone, old, replaced
+	}
+	for i, e := range clone.edges {
+		if e.label == key[0] {
+			newChild, old, replaced := insertTrie(e.node, fullKey, key, value)
+			clone.edges[i] = trieEdge[T]{label: key[0], node: newChild}
+			return clone, old, replaced
+		}
+	}
+	// Keep edges sorted by label so walks visit keys in ascending order.
+	pos := 0
+	for pos < len(clone.edges) && clone.edges[pos].label < key[0] {
+		pos++
+	}
+	clone.edges = append(clone.edges, trieEdge[T]{})
+	copy(clone.edges[pos+1:], clone.edges[pos:])
+	clone.edges[pos] = trieEdge[T]{label: key[0], node: &trieNode[T]{prefix: append([]byte(nil), key...), leaf: &trieLeaf[T]{key: fullKey, value: value}}}
+	var zero T
+	return clone, zero, false
+}
+
+func commonPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Delete removes id from the transaction, returning the removed value if
+// present.
+func (x *Txn[T]) Delete(id NodeID) (old T, deleted bool) {
+	key := nodeIDKey(id)
+	newRoot, old, deleted := deleteTrie(x.root, key)
+	if deleted {
+		x.root = compressTrieNode(newRoot)
+		x.size--
+	}
+	return old, deleted
+}
+
+func deleteTrie[T any](n *trieNode[T], key []byte) (*trieNode[T], T, bool) {
+	var zero T
+	if n == nil || !bytes.HasPrefix(key, n.prefix) {
+		return n, zero, false
+	}
+	key = key[len(n.prefix):]
+	if len(key) == 0 {
+		if n.leaf == nil {
+			return n, zero, false
+		}
+		old := n.leaf.value
+		clone := &trieNode[T]{prefix: n.prefix, edges: n.edges}
+		return compressTrieNode(clone), old, true
+	}
+	for i, e := range n.edges {
+		if e.label == key[0] {
+			newChild, old, deleted := deleteTrie(e.node, key)
+			if !deleted {
+				return n, zero, false
+			}
+			clone := &trieNode[T]{prefix: n.prefix, leaf: n.leaf, edges: append([]trieEdge[T](nil), n.edges...)}
+			if newChild == nil || (newChild.leaf == nil && len(newChild.edges) == 0) {
+				clone.edges = append(clone.edges[:i], clone.edges[i+1:]...)
+			} else {
+				clone.edges[i] = trieEdge[T]{label: key[0], node: newChild}
+			}
+			return compressTrieNode(clone), old, true
+		}
+	}
+	return n, zero, false
+}
+
+// compressTrieNode merges a node left with no leaf and exactly one
+// remaining edge into that edge's node, concatenating prefixes, so the
+// trie's path-compression invariant - no node has a single child unless it
+// also carries a value - is maintained after deletions rather than
+// degrading as chains of single-child nodes accumulate.
+func compressTrieNode[T any](n *trieNode[T]) *trieNode[T] {
+	if n == nil || n.leaf != nil || len(n.edges) != 1 {
+		return n
+	}
+	child := n.edges[0].node
+	merged := append(append([]byte(nil), n.prefix...), child.prefix...)
+	return &trieNode[T]{prefix: merged, leaf: child.leaf, edges: child.edges}
+}
+
+// Get reads a value through the in-progress transaction, seeing this
+// transaction's own uncommitted writes.
+func (x *Txn[T]) Get(id NodeID) (T, bool) {
+	return get(x.root, nodeIDKey(id))
+}
+
+// Commit publishes the transaction's accumulated writes as a new
+// *PrefixTrie, leaving the snapshot this Txn started from untouched.
+func (x *Txn[T]) Commit() *PrefixTrie[T] {
+	return &PrefixTrie[T]{root: x.root, size: x.size}
+}
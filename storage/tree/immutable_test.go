@@ -0,0 +1,103 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import "testing"
+
+func TestPrefixTrieWalkPrefixSubByte(t *testing.T) {
+	trie := NewPrefixTrie[string]()
+	txn := trie.Txn()
+	// 1010011, a 7-bit descendant of the 4-bit prefix 1010.
+	descendant := NodeID{Path: []byte{0b10100110}, PrefixLenBits: 7}
+	txn.Insert(descendant, "descendant")
+	trie = txn.Commit()
+
+	query := NodeID{Path: []byte{0b10100000}, PrefixLenBits: 4}
+	var got []string
+	trie.WalkPrefix(query, func(id NodeID, v string) bool {
+		got = append(got, v)
+		return true
+	})
+	if len(got) != 1 || got[0] != "descendant" {
+		t.Fatalf("WalkPrefix(4-bit query) visited %v, want [descendant]", got)
+	}
+}
+
+func TestPrefixTrieLongestPrefixSubByte(t *testing.T) {
+	trie := NewPrefixTrie[string]()
+	txn := trie.Txn()
+	ancestor := NodeID{Path: []byte{0b10100000}, PrefixLenBits: 4}
+	txn.Insert(ancestor, "ancestor")
+	trie = txn.Commit()
+
+	query := NodeID{Path: []byte{0b10100110}, PrefixLenBits: 7}
+	matched, value, ok := trie.LongestPrefix(query)
+	if !ok || value != "ancestor" || matched.PrefixLenBits != 4 {
+		t.Fatalf("LongestPrefix(7-bit query) = %+v, %q, %v, want 4-bit ancestor, true", matched, value, ok)
+	}
+}
+
+func TestPrefixTrieGetRoundTrip(t *testing.T) {
+	trie := NewPrefixTrie[string]()
+	txn := trie.Txn()
+	ids := []NodeID{
+		{Path: []byte{0xAA, 0x01, 0x01}, PrefixLenBits: 24},
+		{Path: []byte{0xAA, 0x02}, PrefixLenBits: 16},
+		{Path: []byte{0b10100000}, PrefixLenBits: 4},
+		{Path: []byte{0b10100110}, PrefixLenBits: 7},
+	}
+	for i, id := range ids {
+		txn.Insert(id, string(rune('a'+i)))
+	}
+	trie = txn.Commit()
+
+	for i, id := range ids {
+		got, ok := trie.Get(id)
+		want := string(rune('a' + i))
+		if !ok || got != want {
+			t.Errorf("Get(%+v) = %q, %v, want %q, true", id, got, ok, want)
+		}
+	}
+	if got, want := trie.Len(), len(ids); got != want {
+		t.Errorf("Len() = %d, want %d", got, want)
+	}
+}
+
+func TestPrefixTrieDeleteCompressesSingleChildChain(t *testing.T) {
+	trie := NewPrefixTrie[string]()
+	txn := trie.Txn()
+	a := NodeID{Path: []byte{0x00}, PrefixLenBits: 1}
+	b := NodeID{Path: []byte{0x40}, PrefixLenBits: 2}
+	txn.Insert(a, "a")
+	txn.Insert(b, "b")
+	trie = txn.Commit()
+
+	txn = trie.Txn()
+	txn.Delete(a)
+	trie = txn.Commit()
+
+	// After deleting a, b's node (still the tree's only entry) should be
+	// merged back into the root rather than left dangling one edge down.
+	if trie.root.leaf == nil {
+		t.Fatalf("root.leaf = nil, want b's value merged into the root")
+	}
+	if len(trie.root.edges) != 0 {
+		t.Fatalf("root.edges has %d entries, want 0 (b merged into root, no leftover single-child chain)", len(trie.root.edges))
+	}
+	got, ok := trie.Get(b)
+	if !ok || got != "b" {
+		t.Fatalf("Get(b) after deleting a = %q, %v, want \"b\", true", got, ok)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// lpmStride is the number of Path bits consumed per level of an LPMTable,
+// following the Allotment Routing Table layout used for IP lookups: each
+// level holds a fixed 256-entry array indexed by one stride's worth of
+// bits, so a lookup walks depth/stride array accesses rather than depth
+// individual bit tests.
+const lpmStride = 8
+
+// LPMTable answers "what is the deepest stored NodeID prefix of this
+// query" in O(PrefixLenBits/8) with no allocation once built, which is the
+// question repeatedly asked while constructing an inclusion proof: "what is
+// the deepest cached ancestor of this NodeID whose hash I already know?".
+// It replaces the naive approach of successive MaskLeft calls plus map
+// probes, which costs O(depth) hashes and map operations per query.
+//
+// An LPMTable is not safe for concurrent use without external
+// synchronization.
+type LPMTable[T any] struct {
+	root *lpmLevel[T]
+}
+
+// lpmLevel is one 256-entry stride level. entries[b].hasValue distinguishes
+// a stored zero value from an absent one; entries[b].child descends into
+// the next 8 bits of Path when a longer prefix was stored under this byte.
+type lpmLevel[T any] struct {
+	entries [256]lpmEntry[T]
+	// self holds a value stored exactly at this level's stride boundary,
+	// i.e. a NodeID whose PrefixLenBits is a multiple of lpmStride.
+	self *lpmEntrySelf[T]
+}
+
+type lpmEntry[T any] struct {
+	value T
+	// hasValue distinguishes a stored zero value from an absent one.
+	hasValue bool
+	// prefixLen is the PrefixLenBits of the NodeID whose Insert last set
+	// this slot. Partial-stride inserts fan a value out across every byte
+	// value consistent with their prefix, so a single slot can be touched
+	// by inserts of different lengths over time (e.g. a 4-bit prefix's
+	// fan-out spans the same slots as an already-stored 6-bit prefix that
+	// shares its top 4 bits); prefixLen lets Insert/Delete tell which of
+	// those inserts is the more specific one actually occupying a slot, so
+	// a shorter, broader Insert or Delete never clobbers a longer, more
+	// specific entry sharing the same span.
+	prefixLen int
+	child     *lpmLevel[T]
+}
+
+// NewLPMTable returns an empty LPMTable.
+func NewLPMTable[T any]() *LPMTable[T] {
+	return &LPMTable[T]{root: &lpmLevel[T]{}}
+}
+
+// Insert stores value under id, so future Lookups of any NodeID for which
+// id is a prefix will consider it a candidate match.
+func (t *LPMTable[T]) Insert(id NodeID, value T) {
+	level := t.root
+	fullStrides := id.PrefixLenBits / lpmStride
+	for i := 0; i < fullStrides; i++ {
+		b := id.Path[i]
+		e := &level.entries[b]
+		if e.child == nil {
+			e.child = &lpmLevel[T]{}
+		}
+		level = e.child
+	}
+	rem := id.PrefixLenBits % lpmStride
+	if rem == 0 {
+		// PrefixLenBits lands exactly on a stride boundary: there's no
+		// partial byte to fan out over, so the value is filed on the level
+		// itself rather than one of its 256 byte entries.
+		level.self = &lpmEntrySelf[T]{value: value}
+		return
+	}
+	b := id.Path[fullStrides] & leftmask[rem]
+	// Fan the value out across every byte value consistent with the
+	// partial prefix, so a query byte with any suffix bits still matches:
+	// this keeps Lookup a flat array read per stride instead of a second
+	// bit-level comparison. A slot already occupied by a longer, more
+	// specific prefix (e.g. a previously-inserted 6-bit id when id is only
+	// 4 bits) is left alone: id is not a prefix-or-equal match for it, so
+	// overwriting would make that deeper entry unreachable.
+	span := 1 << uint(lpmStride-rem)
+	for i := 0; i < span; i++ {
+		idx := int(b) | i
+		e := &level.entries[idx]
+		if e.hasValue && e.prefixLen > id.PrefixLenBits {
+			continue
+		}
+		e.value = value
+		e.hasValue = true
+		e.prefixLen = id.PrefixLenBits
+	}
+}
+
+// Delete removes id if it was previously inserted with the exact same
+// PrefixLenBits.
+func (t *LPMTable[T]) Delete(id NodeID) {
+	level := t.root
+	fullStrides := id.PrefixLenBits / lpmStride
+	for i := 0; i < fullStrides; i++ {
+		e := &level.entries[id.Path[i]]
+		if e.child == nil {
+			return
+		}
+		level = e.child
+	}
+	rem := id.PrefixLenBits % lpmStride
+	if rem == 0 {
+		level.self = nil
+		return
+	}
+	b := id.Path[fullStrides] & leftmask[rem]
+	span := 1 << uint(lpmStride-rem)
+	for i := 0; i < span; i++ {
+		idx := int(b) | i
+		e := &level.entries[idx]
+		// Only clear slots this exact id's Insert set: a slot now occupied
+		// by a longer, more specific prefix inserted afterwards must
+		// survive deletion of the shorter one.
+		if !e.hasValue || e.prefixLen != id.PrefixLenBits {
+			continue
+		}
+		var zero T
+		e.value = zero
+		e.hasValue = false
+		e.prefixLen = 0
+	}
+}
+
+// Lookup returns the longest stored prefix that is itself a prefix of
+// query, walking lpmStride bits of query.Path at a time and remembering the
+// deepest non-nil value seen along the way. It terminates when it runs out
+// of query.PrefixLenBits or hits a nil child, so cost is bounded by
+// query.PrefixLenBits/lpmStride array accesses with no allocation.
+func (t *LPMTable[T]) Lookup(query NodeID) (matchedPrefixLen int, value T, ok bool) {
+	level := t.root
+	fullStrides := query.PrefixLenBits / lpmStride
+	depth := 0
+	for i := 0; i < fullStrides; i++ {
+		if level.self != nil {
+			matchedPrefixLen, value, ok = depth, level.self.value, true
+		}
+		b := query.Path[i]
+		e := &level.entries[b]
+		if e.hasValue {
+			matchedPrefixLen, value, ok = e.prefixLen, e.value, true
+		}
+		if e.child == nil {
+			return matchedPrefixLen, value, ok
+		}
+		level = e.child
+		depth += lpmStride
+	}
+	if level.self != nil {
+		matchedPrefixLen, value, ok = depth, level.self.value, true
+	}
+	rem := query.PrefixLenBits % lpmStride
+	if rem > 0 {
+		b := query.Path[fullStrides] & leftmask[rem]
+		e := &level.entries[b]
+		// e may have been fanned out by a longer insert sharing query's
+		// partial-byte prefix (e.g. a 6-bit insert reached by a 4-bit
+		// query); that's only a valid match if it's no longer than query
+		// itself.
+		if e.hasValue && e.prefixLen <= query.PrefixLenBits {
+			matchedPrefixLen, value, ok = e.prefixLen, e.value, true
+		}
+	}
+	return matchedPrefixLen, value, ok
+}
+
+// lpmEntrySelf is the payload type for lpmLevel.self.
+type lpmEntrySelf[T any] struct {
+	value T
+}
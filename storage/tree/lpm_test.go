@@ -0,0 +1,84 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import "testing"
+
+func TestLPMTableLongerInsertSurvivesShorterAncestorInsert(t *testing.T) {
+	tbl := NewLPMTable[string]()
+	six := NodeID{Path: []byte{0xA8}, PrefixLenBits: 6}   // 0b101010..
+	four := NodeID{Path: []byte{0xA0}, PrefixLenBits: 4}  // 0b1010.... shares six's top 4 bits.
+	tbl.Insert(six, "six")
+	tbl.Insert(four, "four")
+
+	gotLen, gotVal, ok := tbl.Lookup(six)
+	if !ok || gotLen != 6 || gotVal != "six" {
+		t.Fatalf("Lookup(six) = %d, %q, %v, want 6, %q, true", gotLen, gotVal, ok, "six")
+	}
+}
+
+func TestLPMTableShorterDeleteSurvivesLongerEntry(t *testing.T) {
+	tbl := NewLPMTable[string]()
+	six := NodeID{Path: []byte{0xA8}, PrefixLenBits: 6}
+	four := NodeID{Path: []byte{0xA0}, PrefixLenBits: 4}
+	tbl.Insert(six, "six")
+	tbl.Insert(four, "four")
+
+	tbl.Delete(four)
+
+	gotLen, gotVal, ok := tbl.Lookup(six)
+	if !ok || gotLen != 6 || gotVal != "six" {
+		t.Fatalf("Lookup(six) after deleting ancestor = %d, %q, %v, want 6, %q, true", gotLen, gotVal, ok, "six")
+	}
+
+	// The four-bit entry itself should be gone.
+	shortQuery := NodeID{Path: []byte{0xA0}, PrefixLenBits: 4}
+	if _, _, ok := tbl.Lookup(shortQuery); ok {
+		t.Fatalf("Lookup(four) after Delete(four) = ok, want not found")
+	}
+}
+
+func TestLPMTableLookupReturnsExactMatchedLength(t *testing.T) {
+	tbl := NewLPMTable[string]()
+	tbl.Insert(NodeID{Path: []byte{0xA0}, PrefixLenBits: 4}, "four")
+	tbl.Insert(NodeID{Path: []byte{0xAA, 0x00}, PrefixLenBits: 16}, "sixteen")
+
+	// A query shorter than the deeper 16-bit entry should only match the
+	// 4-bit ancestor, not report the longer entry's length.
+	gotLen, gotVal, ok := tbl.Lookup(NodeID{Path: []byte{0xA5}, PrefixLenBits: 8})
+	if !ok || gotLen != 4 || gotVal != "four" {
+		t.Fatalf("Lookup(8-bit query) = %d, %q, %v, want 4, %q, true", gotLen, gotVal, ok, "four")
+	}
+
+	gotLen, gotVal, ok = tbl.Lookup(NodeID{Path: []byte{0xAA, 0x00}, PrefixLenBits: 16})
+	if !ok || gotLen != 16 || gotVal != "sixteen" {
+		t.Fatalf("Lookup(16-bit query) = %d, %q, %v, want 16, %q, true", gotLen, gotVal, ok, "sixteen")
+	}
+}
+
+func TestLPMTableDeleteThenReinsert(t *testing.T) {
+	tbl := NewLPMTable[string]()
+	id := NodeID{Path: []byte{0x42}, PrefixLenBits: 8}
+	tbl.Insert(id, "v1")
+	tbl.Delete(id)
+	if _, _, ok := tbl.Lookup(id); ok {
+		t.Fatalf("Lookup after Delete = ok, want not found")
+	}
+	tbl.Insert(id, "v2")
+	gotLen, gotVal, ok := tbl.Lookup(id)
+	if !ok || gotLen != 8 || gotVal != "v2" {
+		t.Fatalf("Lookup after reinsert = %d, %q, %v, want 8, %q, true", gotLen, gotVal, ok, "v2")
+	}
+}
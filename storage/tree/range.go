@@ -0,0 +1,200 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import "bytes"
+
+// NodeIDRange describes a half-open [lo, hi) range of NodeID paths. It
+// exists so storage backends (Spanner, MySQL, CockroachDB) can derive their
+// scan bounds from a single shared implementation rather than each
+// hand-rolling [minPath, maxPath) computations from Prefix/Suffix.
+type NodeIDRange struct {
+	lo, hi []byte
+}
+
+// RangeUnderPrefix returns the NodeIDRange covering every NodeID whose Path
+// starts with id's significant bits, i.e. every descendant (and id itself)
+// of the subtree rooted at id.
+func RangeUnderPrefix(id NodeID) NodeIDRange {
+	full := id.PrefixLenBits / 8
+	rem := id.PrefixLenBits % 8
+	lo := append([]byte(nil), id.Path[:full]...)
+	if rem == 0 {
+		hi, ok := incrementBytes(lo)
+		if !ok {
+			// No finite exclusive bound exists (id is the root, or its
+			// Path is all 0xFF bytes): the range extends to the end of
+			// the keyspace.
+			hi = nil
+		}
+		return NodeIDRange{lo: lo, hi: hi}
+	}
+	// A non-byte-aligned prefix: mask off the insignificant low bits of the
+	// partial byte to get lo, and set them all to get hi's exclusive bound.
+	partial := id.Path[full] & leftmask[rem]
+	lo = append(lo, partial)
+	hiPartial := partial | ^leftmask[rem]
+	hiBytes := append([]byte(nil), id.Path[:full]...)
+	hiBytes = append(hiBytes, hiPartial)
+	hi, ok := incrementBytes(hiBytes)
+	if !ok {
+		hi = nil
+	}
+	return NodeIDRange{lo: lo, hi: hi}
+}
+
+// RangeBetween returns the NodeIDRange [lo, hi) between two byte-aligned
+// NodeIDs, using their full Path as the bound. hi is treated as exclusive,
+// matching the storage convention of [minPath, maxPath).
+func RangeBetween(lo, hi NodeID) NodeIDRange {
+	return NodeIDRange{lo: append([]byte(nil), lo.Path...), hi: append([]byte(nil), hi.Path...)}
+}
+
+// EncodeStart returns the inclusive lower bound of the range, suitable for
+// use directly as a storage row-key bound.
+func (r NodeIDRange) EncodeStart() []byte {
+	return append([]byte(nil), r.lo...)
+}
+
+// EncodeLimit returns the exclusive upper bound of the range, or nil if the
+// range is unbounded above (e.g. it covers the rest of the keyspace).
+func (r NodeIDRange) EncodeLimit() []byte {
+	if r.hi == nil {
+		return nil
+	}
+	return append([]byte(nil), r.hi...)
+}
+
+// Contains reports whether id's Path falls within the range.
+func (r NodeIDRange) Contains(id NodeID) bool {
+	return bytes.Compare(id.Path, r.lo) >= 0 && (r.hi == nil || bytes.Compare(id.Path, r.hi) < 0)
+}
+
+// incrementBytes returns the smallest byte slice that is strictly greater,
+// under lexicographic ordering, than every byte slice having b as a
+// prefix, by adding one to b's last byte and carrying as needed, dropping
+// any trailing bytes that overflowed. If b is empty or every byte
+// overflows (b consists entirely of 0xff), no such finite bound exists -
+// any longer continuation of b still compares greater than any
+// byte-length-limited increment - so ok is false and the caller must
+// treat the range as unbounded above.
+func incrementBytes(b []byte) (out []byte, ok bool) {
+	out = append([]byte(nil), b...)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i]++
+		if out[i] != 0 {
+			return out[:i+1], true
+		}
+	}
+	return nil, false
+}
+
+// NodeIDIterator walks NodeIDs in ascending Path order within some range.
+type NodeIDIterator interface {
+	// Next advances the iterator and returns the next NodeID and true, or
+	// a zero NodeID and false if the range is exhausted. If descend is
+	// false, Next skips over any remaining descendants of the
+	// previously-returned NodeID rather than visiting them.
+	Next(descend bool) (NodeID, bool)
+	// SeekGE repositions the iterator so the next call to Next returns the
+	// first NodeID greater than or equal to id.
+	SeekGE(id NodeID)
+}
+
+// trieRangeIterator is a NodeIDIterator over a PrefixTrie[T], walking its
+// entries in key order the way Ethereum state iterators walk a trie node
+// stack, one frame per level of descent.
+type trieRangeIterator[T any] struct {
+	root  *trieNode[T]
+	stack []trieFrame[T]
+	rng   NodeIDRange
+	// skipDepth is the stack depth (len(stack) at return time) of the
+	// frame that produced the most recently returned value, or -1 if
+	// there is none pending. A following Next(false) pops just that one
+	// frame - discarding its still-unvisited edges, i.e. the returned
+	// NodeID's descendants - without touching any frame above it, so
+	// sibling subtrees further up the stack that haven't been visited yet
+	// are left alone.
+	skipDepth int
+}
+
+type trieFrame[T any] struct {
+	node *trieNode[T]
+	next int // index into node.edges of the next child to descend into.
+}
+
+// NewTrieIterator returns a NodeIDIterator over every entry in t that falls
+// within rng.
+func NewTrieIterator[T any](t *PrefixTrie[T], rng NodeIDRange) NodeIDIterator {
+	it := &trieRangeIterator[T]{rng: rng, skipDepth: -1}
+	if t != nil && t.root != nil {
+		it.root = t.root
+		it.stack = []trieFrame[T]{{node: t.root}}
+	}
+	return it
+}
+
+func (it *trieRangeIterator[T]) Next(descend bool) (NodeID, bool) {
+	if !descend && it.skipDepth == len(it.stack) {
+		// The caller doesn't want the previously-returned value's
+		// descendants: pop exactly the frame that produced it, leaving
+		// every ancestor frame - and whatever unvisited siblings they
+		// still hold - untouched.
+		it.stack = it.stack[:len(it.stack)-1]
+	}
+	it.skipDepth = -1
+	for len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		if top.next == 0 && top.node.leaf != nil {
+			top.next++
+			id := decodeNodeIDKey(top.node.leaf.key)
+			if it.rng.Contains(id) {
+				it.skipDepth = len(it.stack)
+				return id, true
+			}
+			continue
+		}
+		idx := top.next - boolToInt(top.node.leaf != nil)
+		if idx >= len(top.node.edges) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		top.next++
+		it.stack = append(it.stack, trieFrame[T]{node: top.node.edges[idx].node})
+	}
+	return NodeID{}, false
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SeekGE discards the iterator's current position and restarts scanning
+// from id; since PrefixTrie has no sibling-order index readily available
+// for a mid-tree jump, this is implemented as narrowing the range's lower
+// bound and resetting to the root, which is correct but not asymptotically
+// better than a fresh Next() walk from id.
+func (it *trieRangeIterator[T]) SeekGE(id NodeID) {
+	if bytes.Compare(id.Path, it.rng.lo) > 0 {
+		it.rng.lo = append([]byte(nil), id.Path...)
+	}
+	if it.root != nil {
+		it.stack = []trieFrame[T]{{node: it.root}}
+	}
+	it.skipDepth = -1
+}
@@ -0,0 +1,151 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import "testing"
+
+func TestRangeUnderPrefixRoot(t *testing.T) {
+	rng := RangeUnderPrefix(NodeID{Path: []byte{}, PrefixLenBits: 0})
+	id := NodeID{Path: []byte{200, 5}, PrefixLenBits: 16}
+	if !rng.Contains(id) {
+		t.Errorf("RangeUnderPrefix(root).Contains(%v) = false, want true", id)
+	}
+}
+
+func TestRangeUnderPrefixAllOnes(t *testing.T) {
+	rng := RangeUnderPrefix(NodeID{Path: []byte{0xFF}, PrefixLenBits: 8})
+	id := NodeID{Path: []byte{0xFF, 0x05}, PrefixLenBits: 16}
+	if !rng.Contains(id) {
+		t.Errorf("RangeUnderPrefix(0xFF).Contains(%v) = false, want true", id)
+	}
+}
+
+func TestRangeUnderPrefixByteAligned(t *testing.T) {
+	rng := RangeUnderPrefix(NodeID{Path: []byte{5}, PrefixLenBits: 8})
+	inside := NodeID{Path: []byte{5, 9}, PrefixLenBits: 16}
+	outside := NodeID{Path: []byte{6}, PrefixLenBits: 8}
+	if !rng.Contains(inside) {
+		t.Errorf("Contains(%v) = false, want true", inside)
+	}
+	if rng.Contains(outside) {
+		t.Errorf("Contains(%v) = true, want false", outside)
+	}
+}
+
+func TestRangeUnderPrefixSubByte(t *testing.T) {
+	// A 4-bit prefix of 0b1010: covers 0b10100000 through 0b10101111.
+	rng := RangeUnderPrefix(NodeID{Path: []byte{0xA0}, PrefixLenBits: 4})
+	inside := NodeID{Path: []byte{0xAF}, PrefixLenBits: 8}
+	outside := NodeID{Path: []byte{0xB0}, PrefixLenBits: 8}
+	if !rng.Contains(inside) {
+		t.Errorf("Contains(%v) = false, want true", inside)
+	}
+	if rng.Contains(outside) {
+		t.Errorf("Contains(%v) = true, want false", outside)
+	}
+}
+
+func idList(ids ...NodeID) []NodeID { return ids }
+
+func buildTrieIterator(t *testing.T, ids []NodeID, rng NodeIDRange) NodeIDIterator {
+	t.Helper()
+	trie := NewPrefixTrie[int]()
+	txn := trie.Txn()
+	for i, id := range ids {
+		txn.Insert(id, i)
+	}
+	return NewTrieIterator[int](txn.Commit(), rng)
+}
+
+func TestTrieIteratorNextVisitsAllInOrder(t *testing.T) {
+	zero := NodeID{Path: []byte{0x00}, PrefixLenBits: 1}
+	zeroZeroOne := NodeID{Path: []byte{0b00100000}, PrefixLenBits: 3}
+	one := NodeID{Path: []byte{0x80}, PrefixLenBits: 1}
+	it := buildTrieIterator(t, idList(zero, zeroZeroOne, one), RangeUnderPrefix(NodeID{}))
+
+	var got []NodeID
+	for {
+		id, ok := it.Next(true)
+		if !ok {
+			break
+		}
+		got = append(got, id)
+	}
+	want := []NodeID{zero, zeroZeroOne, one}
+	if len(got) != len(want) {
+		t.Fatalf("Next(true) visited %d ids, want %d: got=%v want=%v", len(got), len(want), got, want)
+	}
+	for i := range want {
+		if got[i].PrefixLenBits != want[i].PrefixLenBits || !bytesEqual(got[i].Path, want[i].Path) {
+			t.Errorf("got[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func bytesEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestTrieIteratorNextFalseOnlySkipsYieldedSubtree is the maintainer's
+// repro: skipping the descendants of a returned node must not drop an
+// unrelated, not-yet-visited sibling subtree higher up the stack.
+func TestTrieIteratorNextFalseOnlySkipsYieldedSubtree(t *testing.T) {
+	zero := NodeID{Path: []byte{0x00}, PrefixLenBits: 1}
+	zeroZeroOne := NodeID{Path: []byte{0b00100000}, PrefixLenBits: 3} // descendant of zero.
+	one := NodeID{Path: []byte{0x80}, PrefixLenBits: 1}               // unrelated sibling of zero.
+	it := buildTrieIterator(t, idList(zero, zeroZeroOne, one), RangeUnderPrefix(NodeID{}))
+
+	got, ok := it.Next(true)
+	if !ok || got.PrefixLenBits != 1 || got.Path[0] != 0x00 {
+		t.Fatalf("first Next(true) = %+v, %v, want zero", got, ok)
+	}
+
+	got, ok = it.Next(false)
+	if !ok {
+		t.Fatalf("Next(false) = not found, want the unrelated sibling %+v", one)
+	}
+	if got.PrefixLenBits != one.PrefixLenBits || !bytesEqual(got.Path, one.Path) {
+		t.Fatalf("Next(false) = %+v, want unrelated sibling %+v (it must skip only zero's descendants, not pop ancestor frames)", got, one)
+	}
+
+	if _, ok := it.Next(true); ok {
+		t.Fatalf("expected iterator exhausted after visiting zero (skipped) and one")
+	}
+}
+
+func TestTrieIteratorSeekGE(t *testing.T) {
+	zero := NodeID{Path: []byte{0x00}, PrefixLenBits: 1}
+	zeroZeroOne := NodeID{Path: []byte{0b00100000}, PrefixLenBits: 3}
+	one := NodeID{Path: []byte{0x80}, PrefixLenBits: 1}
+	it := buildTrieIterator(t, idList(zero, zeroZeroOne, one), RangeUnderPrefix(NodeID{}))
+
+	it.SeekGE(NodeID{Path: []byte{0x10}, PrefixLenBits: 8})
+	got, ok := it.Next(true)
+	if !ok || got.PrefixLenBits != zeroZeroOne.PrefixLenBits || !bytesEqual(got.Path, zeroZeroOne.Path) {
+		t.Fatalf("Next after SeekGE = %+v, %v, want %+v", got, ok, zeroZeroOne)
+	}
+	got, ok = it.Next(true)
+	if !ok || got.PrefixLenBits != one.PrefixLenBits || !bytesEqual(got.Path, one.Path) {
+		t.Fatalf("Next after SeekGE (2nd) = %+v, %v, want %+v", got, ok, one)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+// CompressedSibling is one entry of a SiblingsCompressed() result. It
+// either identifies a single populated sibling (SkipLevels == 0), or the
+// topmost node of a contiguous run of SkipLevels+1 empty siblings that all
+// hash to their level's well-known empty subtree value.
+type CompressedSibling struct {
+	// Node is the highest (closest to the root) sibling in the run.
+	Node NodeID
+	// SkipLevels is the number of additional empty sibling levels below
+	// Node that are being collapsed into this entry, so the run covers
+	// SkipLevels+1 levels in total.
+	SkipLevels int
+	// Hash is the sibling's hash for a populated entry (SkipLevels == 0).
+	// SiblingsCompressed only knows presence, not the hash value itself, so
+	// it leaves this nil; the caller fills it in from its subtree data
+	// before passing the path to RecomputeRootFromCompressed. It stays nil
+	// for a run of empty siblings, whose hashes are derived on demand from
+	// emptyHashForLevel instead.
+	Hash []byte
+}
+
+// SiblingsCompressed returns the siblings of n on the path to the root, in
+// the same closest-leaf-first order as Siblings(), but with contiguous runs
+// of siblings that have no populated descendant collapsed into a single
+// CompressedSibling entry recording how many levels were skipped.
+//
+// populated(id) must report whether any leaf lives under id; a subtree
+// index such as SuffixIndex can answer this cheaply via IteratePrefix. For
+// a sparse Merkle tree the vast majority of siblings are empty, so this
+// typically returns O(log(populated leaves)) entries rather than
+// PrefixLenBits.
+func (n NodeID) SiblingsCompressed(populated func(NodeID) bool) []CompressedSibling {
+	var out []CompressedSibling
+	var run *CompressedSibling
+	for height := 0; height < n.PrefixLenBits; height++ {
+		depth := n.PrefixLenBits - height
+		sib := n.Neighbor(depth)
+		if populated(sib) {
+			run = nil
+			out = append(out, CompressedSibling{Node: sib})
+			continue
+		}
+		if run == nil {
+			out = append(out, CompressedSibling{Node: sib})
+			run = &out[len(out)-1]
+		} else {
+			run.SkipLevels++
+			run.Node = sib
+		}
+	}
+	return out
+}
+
+// RecomputeRootFromCompressed rebuilds the root hash for leaf given the
+// compressed sibling path produced by SiblingsCompressed (with Hash filled
+// in on every populated entry by the caller). emptyHashForLevel returns the
+// well-known empty subtree hash for the sibling at the given height (0 ==
+// adjacent to the leaf); hashChildren combines a left/right pair into their
+// parent's hash, and bitAt reports which side of that pair leaf's ancestor
+// falls on at a given height. The path holds len(path) entries rather than
+// one per level, but each entry still costs SkipLevels+1 hashChildren calls,
+// one per level it covers, so a 256-bit map takes 256 hashChildren calls
+// whatever the number of populated leaves. The hashes of skipped empty
+// siblings come from emptyHashForLevel rather than from the path.
+func RecomputeRootFromCompressed(leaf []byte, path []CompressedSibling, emptyHashForLevel func(height int) []byte, hashChildren func(left, right []byte) []byte, bitAt func(height int) uint) []byte {
+	hash := leaf
+	height := 0
+	for _, entry := range path {
+		levels := entry.SkipLevels + 1
+		for l := 0; l < levels; l++ {
+			sibHash := emptyHashForLevel(height)
+			if l == levels-1 && entry.Hash != nil {
+				sibHash = entry.Hash
+			}
+			if bitAt(height) == 1 {
+				hash = hashChildren(sibHash, hash)
+			} else {
+				hash = hashChildren(hash, sibHash)
+			}
+			height++
+		}
+	}
+	return hash
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tree
+
+import (
+	"hash/fnv"
+	"testing"
+)
+
+// fnvHashChildren is a deterministic stand-in for a real Merkle hash
+// function: enough to tell the compressed and uncompressed recompute paths
+// apart if they combine siblings in a different order or at a different
+// height.
+func fnvHashChildren(left, right []byte) []byte {
+	h := fnv.New32a()
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+func fnvEmptyHashForLevel(height int) []byte {
+	return []byte{byte(height + 1)}
+}
+
+// bitAtForNode mirrors the bit NodeID.Neighbor flips at a given sibling
+// height, i.e. which side of the pair n's ancestor falls on at that height.
+func bitAtForNode(n NodeID, height int) uint {
+	h := n.PathLenBits() - n.PrefixLenBits + height
+	return n.Bit(h)
+}
+
+// recomputeUncompressedRoot walks every level individually (no skipping),
+// for comparison against RecomputeRootFromCompressed's skip-level path.
+func recomputeUncompressedRoot(leaf []byte, n NodeID, hashes map[string][]byte) []byte {
+	hash := leaf
+	sibs := n.Siblings()
+	for height, sib := range sibs {
+		sibHash, ok := hashes[sib.AsKey()]
+		if !ok {
+			sibHash = fnvEmptyHashForLevel(height)
+		}
+		if bitAtForNode(n, height) == 1 {
+			hash = fnvHashChildren(sibHash, hash)
+		} else {
+			hash = fnvHashChildren(hash, sibHash)
+		}
+	}
+	return hash
+}
+
+func TestSiblingsCompressedInterleavedRuns(t *testing.T) {
+	n := NodeID{Path: []byte{0xB0}, PrefixLenBits: 4}
+	sibs := n.Siblings() // height 0..3, leaf-closest first.
+
+	// Only the height-1 sibling is populated: an empty run either side of
+	// it, one of length 1 (height 0) and one of length 2 (heights 2-3).
+	populated := map[string]bool{sibs[1].AsKey(): true}
+	path := n.SiblingsCompressed(func(sib NodeID) bool { return populated[sib.AsKey()] })
+
+	if len(path) != 3 {
+		t.Fatalf("SiblingsCompressed returned %d entries, want 3: %+v", len(path), path)
+	}
+
+	if path[0].Node.AsKey() != sibs[0].AsKey() || path[0].SkipLevels != 0 {
+		t.Errorf("path[0] = %+v, want Node=%v SkipLevels=0", path[0], sibs[0])
+	}
+	if path[1].Node.AsKey() != sibs[1].AsKey() || path[1].SkipLevels != 0 {
+		t.Errorf("path[1] = %+v, want Node=%v SkipLevels=0 (populated)", path[1], sibs[1])
+	}
+	// The empty run spanning heights 2-3 must record the sibling closest to
+	// the root (height 3, the last one folded in), per the Node doc comment.
+	if path[2].Node.AsKey() != sibs[3].AsKey() || path[2].SkipLevels != 1 {
+		t.Errorf("path[2] = %+v, want Node=%v (root-closest of the run) SkipLevels=1", path[2], sibs[3])
+	}
+}
+
+func TestRecomputeRootFromCompressedMatchesUncompressed(t *testing.T) {
+	n := NodeID{Path: []byte{0xB0}, PrefixLenBits: 4}
+	sibs := n.Siblings()
+	leafHash := []byte("leaf")
+
+	populated := map[string]bool{sibs[1].AsKey(): true}
+	hashes := map[string][]byte{sibs[1].AsKey(): []byte("sib1-hash")}
+
+	path := n.SiblingsCompressed(func(sib NodeID) bool { return populated[sib.AsKey()] })
+	for i := range path {
+		if path[i].SkipLevels == 0 {
+			if h, ok := hashes[path[i].Node.AsKey()]; ok {
+				path[i].Hash = h
+			}
+		}
+	}
+
+	got := RecomputeRootFromCompressed(leafHash, path, fnvEmptyHashForLevel, fnvHashChildren, func(height int) uint {
+		return bitAtForNode(n, height)
+	})
+	want := recomputeUncompressedRoot(leafHash, n, hashes)
+	if string(got) != string(want) {
+		t.Errorf("RecomputeRootFromCompressed = %x, want %x (uncompressed walk)", got, want)
+	}
+}
+
+func TestSiblingsCompressedAllEmpty(t *testing.T) {
+	n := NodeID{Path: []byte{0x00}, PrefixLenBits: 4}
+	sibs := n.Siblings()
+	path := n.SiblingsCompressed(func(NodeID) bool { return false })
+	if len(path) != 1 {
+		t.Fatalf("SiblingsCompressed (all empty) returned %d entries, want 1: %+v", len(path), path)
+	}
+	if path[0].SkipLevels != len(sibs)-1 {
+		t.Errorf("path[0].SkipLevels = %d, want %d", path[0].SkipLevels, len(sibs)-1)
+	}
+	if path[0].Node.AsKey() != sibs[len(sibs)-1].AsKey() {
+		t.Errorf("path[0].Node = %v, want root-closest sibling %v", path[0].Node, sibs[len(sibs)-1])
+	}
+}